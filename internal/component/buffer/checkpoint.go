@@ -0,0 +1,291 @@
+package buffer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+// FsyncPolicy controls how aggressively a Checkpoint flushes its persisted
+// state to stable storage.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways calls fsync after every flush to disk.
+	FsyncAlways FsyncPolicy = "always"
+
+	// FsyncInterval skips the fsync on every individual flush, relying
+	// instead on the periodic flush loop (and the final flush on Close) to
+	// fsync, trading a bounded window of durability for throughput on writes
+	// forced early by FlushOnUpdates.
+	FsyncInterval FsyncPolicy = "interval"
+
+	// FsyncNever never explicitly calls fsync.
+	FsyncNever FsyncPolicy = "never"
+)
+
+// CheckpointConfig configures a Checkpoint.
+type CheckpointConfig struct {
+	// Path is the file that the checkpoint registry is persisted to.
+	Path string
+
+	// FlushInterval is the maximum period between writes of the registry to
+	// disk, regardless of how many updates have accumulated.
+	FlushInterval time.Duration
+
+	// FlushOnUpdates forces a flush once this many updates have accumulated
+	// since the last one, regardless of FlushInterval.
+	FlushOnUpdates int
+
+	// Fsync selects how aggressively writes are synced to stable storage.
+	Fsync FsyncPolicy
+}
+
+// Checkpoint is a reusable, registrar-style durable checkpoint layer that any
+// BatchBuffer implementation can embed in order to persist per-source read
+// offsets to disk, modelled on the Filebeat registrar. It allows a buffer to
+// recover the last-persisted offsets on startup and therefore resume reading
+// without re-emitting batches that have already been acknowledged.
+type Checkpoint struct {
+	conf CheckpointConfig
+	log  log.Modular
+
+	mReadOffset    metrics.StatGauge
+	mPersistOffset metrics.StatGauge
+	mLag           metrics.StatGauge
+	mFlushes       metrics.StatCounter
+	mFlushErr      metrics.StatCounter
+
+	mut               sync.Mutex
+	offsets           map[string]uint64
+	highestRead       uint64
+	highestPersisted  uint64
+	updatesSinceFlush int
+
+	// unsynced is set when the last write under FsyncInterval skipped fsync,
+	// so that the next periodic flush syncs it even without new updates.
+	unsynced bool
+
+	closeChan chan struct{}
+	closeOnce sync.Once
+	loopDone  chan struct{}
+}
+
+// NewCheckpoint creates a Checkpoint that persists its registry to
+// conf.Path. Unset durations and thresholds fall back to sane defaults.
+func NewCheckpoint(conf CheckpointConfig, log log.Modular, stats metrics.Type) *Checkpoint {
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = time.Second
+	}
+	if conf.FlushOnUpdates <= 0 {
+		conf.FlushOnUpdates = 100
+	}
+	if conf.Fsync == "" {
+		conf.Fsync = FsyncInterval
+	}
+	c := &Checkpoint{
+		conf:           conf,
+		log:            log,
+		mReadOffset:    stats.GetGauge("checkpoint.read_offset"),
+		mPersistOffset: stats.GetGauge("checkpoint.persisted_offset"),
+		mLag:           stats.GetGauge("checkpoint.lag"),
+		mFlushes:       stats.GetCounter("checkpoint.flush.count"),
+		mFlushErr:      stats.GetCounter("checkpoint.flush.error"),
+		offsets:        map[string]uint64{},
+		closeChan:      make(chan struct{}),
+		loopDone:       make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// Recover loads the last-persisted offsets from disk, if a registry file is
+// present, and returns them keyed by source ID. It must be called before any
+// call to Update in order to avoid clobbering the recovered state.
+func (c *Checkpoint) Recover() (map[string]uint64, error) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	data, err := os.ReadFile(c.conf.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]uint64{}, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoint registry: %w", err)
+	}
+
+	offsets := map[string]uint64{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &offsets); err != nil {
+			return nil, fmt.Errorf("failed to parse checkpoint registry: %w", err)
+		}
+	}
+
+	c.offsets = offsets
+	for _, offset := range offsets {
+		if offset > c.highestRead {
+			c.highestRead = offset
+		}
+		if offset > c.highestPersisted {
+			c.highestPersisted = offset
+		}
+	}
+
+	recovered := make(map[string]uint64, len(offsets))
+	for k, v := range offsets {
+		recovered[k] = v
+	}
+	return recovered, nil
+}
+
+// Update records the latest read offset for a given source. Offsets are only
+// ever moved forward. A flush to disk is triggered immediately if the
+// FlushOnUpdates threshold has been reached or the fsync policy is
+// FsyncAlways; otherwise the update is picked up by the next periodic flush.
+func (c *Checkpoint) Update(sourceID string, offset uint64) {
+	c.mut.Lock()
+	if existing := c.offsets[sourceID]; offset <= existing {
+		c.mut.Unlock()
+		return
+	}
+	c.offsets[sourceID] = offset
+	if offset > c.highestRead {
+		c.highestRead = offset
+	}
+	c.updatesSinceFlush++
+	c.mReadOffset.Set(int64(c.highestRead))
+	c.mLag.Set(int64(c.highestRead - c.highestPersisted))
+
+	shouldFlush := c.conf.Fsync == FsyncAlways || c.updatesSinceFlush >= c.conf.FlushOnUpdates
+	c.mut.Unlock()
+
+	if shouldFlush {
+		// A flush forced early by FlushOnUpdates doesn't get the periodic
+		// loop's fsync under FsyncInterval; only FsyncAlways syncs here.
+		c.flush(c.conf.Fsync == FsyncAlways)
+	}
+}
+
+// WrapAckFunc returns an AckFunc that only advances the checkpoint for
+// sourceID to offset once the wrapped AckFunc reports a successful delivery,
+// allowing custom BatchBuffer implementations to tie their checkpoint
+// advancement to downstream confirmation rather than local processing.
+func (c *Checkpoint) WrapAckFunc(sourceID string, offset uint64, inner AckFunc) AckFunc {
+	return func(ctx context.Context, err error) error {
+		if err == nil {
+			c.Update(sourceID, offset)
+		}
+		return inner(ctx, err)
+	}
+}
+
+func (c *Checkpoint) loop() {
+	defer close(c.loopDone)
+	ticker := time.NewTicker(c.conf.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The periodic tick is what makes FsyncInterval durable: even
+			// though individual writes between ticks may skip fsync, this
+			// flush always syncs.
+			c.flush(true)
+		case <-c.closeChan:
+			c.flush(true)
+			return
+		}
+	}
+}
+
+// flush persists the current offsets snapshot to disk. sync additionally
+// forces an fsync of the write regardless of FsyncPolicy, used by the
+// periodic loop and the final flush on Close to bound how long an
+// FsyncInterval checkpoint can go without being durably on disk.
+func (c *Checkpoint) flush(sync bool) {
+	c.mut.Lock()
+	if c.updatesSinceFlush == 0 && !(sync && c.unsynced) {
+		c.mut.Unlock()
+		return
+	}
+	offsets := make(map[string]uint64, len(c.offsets))
+	for k, v := range c.offsets {
+		offsets[k] = v
+	}
+	highestRead := c.highestRead
+	n := c.updatesSinceFlush
+	c.mut.Unlock()
+
+	if err := c.writeRegistry(offsets, sync); err != nil {
+		c.mFlushErr.Incr(1)
+		c.log.Errorf("Failed to persist checkpoint registry: %v\n", err)
+		return
+	}
+
+	c.mut.Lock()
+	c.highestPersisted = highestRead
+	// Subtract only the updates accounted for by the snapshot just written,
+	// not reset to zero - an Update that landed while writeRegistry was in
+	// flight bumped the counter again for an offset that isn't in offsets,
+	// and must still trigger a future flush rather than being forgotten.
+	c.updatesSinceFlush -= n
+	c.unsynced = !sync && c.conf.Fsync == FsyncInterval
+	lag := c.highestRead - c.highestPersisted
+	c.mut.Unlock()
+
+	c.mFlushes.Incr(1)
+	c.mPersistOffset.Set(int64(highestRead))
+	c.mLag.Set(int64(lag))
+}
+
+// writeRegistry persists offsets via the write-temp-then-rename pattern so
+// that a crash mid-write never leaves a partially written registry file in
+// place. sync controls whether the temp file is fsynced before the rename;
+// callers decide this based on FsyncPolicy, except FsyncNever which always
+// skips it regardless of sync.
+func (c *Checkpoint) writeRegistry(offsets map[string]uint64, sync bool) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(c.conf.Path)
+	tmp, err := os.CreateTemp(dir, ".checkpoint-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if sync && c.conf.Fsync != FsyncNever {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, c.conf.Path)
+}
+
+// Close flushes any outstanding updates and stops the background flush loop.
+func (c *Checkpoint) Close() {
+	c.closeOnce.Do(func() { close(c.closeChan) })
+	<-c.loopDone
+}
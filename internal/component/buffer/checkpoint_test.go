@@ -0,0 +1,52 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+func TestCheckpointWrapAckFuncAdvancesOnSuccess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+	c := NewCheckpoint(CheckpointConfig{Path: path, Fsync: FsyncAlways}, log.Noop(), metrics.Noop())
+	defer c.Close()
+
+	inner := func(context.Context, error) error { return nil }
+
+	if err := c.WrapAckFunc("source-a", 5, inner)(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected ack error: %v", err)
+	}
+	if err := c.WrapAckFunc("source-a", 10, inner)(context.Background(), errors.New("delivery failed")); err != nil {
+		t.Fatalf("unexpected ack error: %v", err)
+	}
+
+	c.mut.Lock()
+	offset := c.offsets["source-a"]
+	c.mut.Unlock()
+	if offset != 5 {
+		t.Fatalf("expected checkpoint to only advance on successful delivery, got offset %d", offset)
+	}
+}
+
+func TestCheckpointRecoverAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.json")
+
+	c := NewCheckpoint(CheckpointConfig{Path: path, Fsync: FsyncAlways}, log.Noop(), metrics.Noop())
+	c.Update("source-a", 42)
+	c.Close()
+
+	c2 := NewCheckpoint(CheckpointConfig{Path: path}, log.Noop(), metrics.Noop())
+	defer c2.Close()
+
+	recovered, err := c2.Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered["source-a"] != 42 {
+		t.Fatalf("expected recovered offset 42, got %d", recovered["source-a"])
+	}
+}
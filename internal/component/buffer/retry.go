@@ -0,0 +1,170 @@
+package buffer
+
+import (
+	"sync"
+	"time"
+)
+
+// BufferRetryPolicy controls how a Stream responds to repeated failures when
+// reading from its underlying buffer.
+type BufferRetryPolicy interface {
+	// NextBackoff is called after a failed read attempt and returns how long
+	// to wait before trying again. The attempt number starts at 1 for the
+	// first failure following a success (or start-up). A false return value
+	// indicates the policy has given up, and the Stream should shut down
+	// rather than retry further.
+	NextBackoff(attempt int, err error) (time.Duration, bool)
+
+	// Reset is called after a successful read, clearing any accumulated
+	// failure state.
+	Reset()
+}
+
+// CircuitStater is an optional interface a BufferRetryPolicy may implement in
+// order to expose whether it currently considers its circuit breaker open,
+// allowing callers to surface this as a metric.
+type CircuitStater interface {
+	CircuitOpen() bool
+}
+
+//------------------------------------------------------------------------------
+
+// ExponentialBackoffRetryPolicy doubles its wait duration after each
+// consecutive failure, up to Max, optionally giving up after MaxRetries
+// attempts.
+type ExponentialBackoffRetryPolicy struct {
+	// Initial is the backoff duration after the first failure.
+	Initial time.Duration
+
+	// Max is the largest backoff duration this policy will return.
+	Max time.Duration
+
+	// MaxRetries caps the number of consecutive retries before the policy
+	// gives up. Zero means retry indefinitely.
+	MaxRetries int
+}
+
+// NextBackoff implements BufferRetryPolicy.
+func (e *ExponentialBackoffRetryPolicy) NextBackoff(attempt int, err error) (time.Duration, bool) {
+	if e.MaxRetries > 0 && attempt > e.MaxRetries {
+		return 0, false
+	}
+	backoff := e.Initial
+	for i := 1; i < attempt && backoff < e.Max; i++ {
+		backoff *= 2
+	}
+	if backoff > e.Max {
+		backoff = e.Max
+	}
+	return backoff, true
+}
+
+// Reset implements BufferRetryPolicy.
+func (e *ExponentialBackoffRetryPolicy) Reset() {}
+
+//------------------------------------------------------------------------------
+
+// ConstantRetryPolicy waits a fixed interval between each retry, optionally
+// giving up after MaxRetries attempts.
+type ConstantRetryPolicy struct {
+	// Interval is the fixed wait between retries.
+	Interval time.Duration
+
+	// MaxRetries caps the number of consecutive retries before the policy
+	// gives up. Zero means retry indefinitely.
+	MaxRetries int
+}
+
+// NextBackoff implements BufferRetryPolicy.
+func (c *ConstantRetryPolicy) NextBackoff(attempt int, err error) (time.Duration, bool) {
+	if c.MaxRetries > 0 && attempt > c.MaxRetries {
+		return 0, false
+	}
+	return c.Interval, true
+}
+
+// Reset implements BufferRetryPolicy.
+func (c *ConstantRetryPolicy) Reset() {}
+
+//------------------------------------------------------------------------------
+
+// CircuitBreakerRetryPolicy opens its circuit after FailThreshold
+// consecutive read failures, refusing further retries until CoolDown has
+// elapsed. The first failure reported once the cool-down has passed is
+// answered with a single immediate half-open retry; a failure of that retry
+// re-opens the circuit, while a success (observed via Reset) closes it.
+type CircuitBreakerRetryPolicy struct {
+	// FailThreshold is the number of consecutive failures before the
+	// circuit opens.
+	FailThreshold int
+
+	// CoolDown is how long the circuit stays open before allowing a single
+	// half-open retry.
+	CoolDown time.Duration
+
+	// Backoff is used to space out retries while the circuit is closed.
+	Backoff BufferRetryPolicy
+
+	mut      sync.Mutex
+	open     bool
+	halfOpen bool
+	openedAt time.Time
+}
+
+// NextBackoff implements BufferRetryPolicy. The breaker never tells the
+// caller to give up outright - a Stream treating NextBackoff's bool as
+// "give up permanently" would shut itself down the first time the circuit
+// opens, and then never call NextBackoff again to observe the cool-down or
+// half-open transitions. Instead, while open it reports the remaining
+// cool-down (or the full cool-down again, for a failed half-open probe) as
+// the backoff to wait out, so the Stream keeps calling back in and the
+// breaker can progress through half-open to closed.
+func (c *CircuitBreakerRetryPolicy) NextBackoff(attempt int, err error) (time.Duration, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.open {
+		if c.halfOpen {
+			// The half-open probe failed; re-open the circuit for another
+			// full cool-down before trying again.
+			c.halfOpen = false
+			c.openedAt = time.Now()
+			return c.CoolDown, true
+		}
+		if remaining := c.CoolDown - time.Since(c.openedAt); remaining > 0 {
+			return remaining, true
+		}
+		// Cool-down has elapsed: allow a single half-open retry attempt.
+		c.halfOpen = true
+		return 0, true
+	}
+
+	if attempt >= c.FailThreshold {
+		c.open = true
+		c.openedAt = time.Now()
+		return c.CoolDown, true
+	}
+
+	if c.Backoff != nil {
+		return c.Backoff.NextBackoff(attempt, err)
+	}
+	return 0, true
+}
+
+// Reset implements BufferRetryPolicy. A successful read closes the circuit.
+func (c *CircuitBreakerRetryPolicy) Reset() {
+	c.mut.Lock()
+	c.open = false
+	c.halfOpen = false
+	c.mut.Unlock()
+	if c.Backoff != nil {
+		c.Backoff.Reset()
+	}
+}
+
+// CircuitOpen implements CircuitStater.
+func (c *CircuitBreakerRetryPolicy) CircuitOpen() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.open
+}
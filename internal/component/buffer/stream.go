@@ -13,7 +13,6 @@ import (
 	"github.com/Jeffail/benthos/v3/lib/metrics"
 	"github.com/Jeffail/benthos/v3/lib/response"
 	"github.com/Jeffail/benthos/v3/lib/types"
-	"github.com/Jeffail/benthos/v3/lib/util/throttle"
 )
 
 // AckFunc is a function used to acknowledge receipt of a message batch from a
@@ -46,6 +45,20 @@ type ReaderWriter interface {
 	Close(context.Context) error
 }
 
+// AsyncCloser is an optional interface that a ReaderWriter may implement in
+// order to close itself in the background rather than block the caller of
+// Close, mirroring the libp2p AsyncCloser pattern. This is useful for large
+// persistent buffers (SQLite, disk-queue, remote stores) that can take a
+// significant amount of time to flush.
+//
+// AsyncClose should return immediately, having begun the close in the
+// background, and must call onDone exactly once the buffer has finished
+// closing. The buffer must not be read from or written to once AsyncClose
+// has been called.
+type AsyncCloser interface {
+	AsyncClose(onDone func()) error
+}
+
 // Stream wraps a read/write buffer implementation with a channel based
 // streaming component that satisfies the internal Benthos Consumer and Producer
 // interfaces.
@@ -56,29 +69,42 @@ type Stream struct {
 
 	buffer ReaderWriter
 
-	errThrottle *throttle.Type
+	retryPolicy BufferRetryPolicy
 	shutSig     *shutdown.Signaller
 
 	messagesIn  <-chan types.Transaction
 	messagesOut chan types.Transaction
 
-	closedWG sync.WaitGroup
+	drainedChan chan struct{}
+	closedWG    sync.WaitGroup
 }
 
-// NewStream creates a new Producer/Consumer around a buffer.
+// NewStream creates a new Producer/Consumer around a buffer. Reads that fail
+// are retried according to a default exponential backoff policy; call
+// SetRetryPolicy before Consume to configure a different one.
 func NewStream(typeStr string, buffer ReaderWriter, log log.Modular, stats metrics.Type) buffer.Type {
 	m := Stream{
-		typeStr:     typeStr,
-		stats:       stats,
-		log:         log,
-		buffer:      buffer,
+		typeStr: typeStr,
+		stats:   stats,
+		log:     log,
+		buffer:  buffer,
+		retryPolicy: &ExponentialBackoffRetryPolicy{
+			Initial: time.Millisecond * 100,
+			Max:     time.Second * 5,
+		},
 		shutSig:     shutdown.NewSignaller(),
 		messagesOut: make(chan types.Transaction),
+		drainedChan: make(chan struct{}),
 	}
-	m.errThrottle = throttle.New(throttle.OptCloseChan(m.shutSig.CloseAtLeisureChan()))
 	return &m
 }
 
+// SetRetryPolicy overrides the policy used to back off and eventually give up
+// on repeated buffer read failures. It must be called before Consume.
+func (m *Stream) SetRetryPolicy(p BufferRetryPolicy) {
+	m.retryPolicy = p
+}
+
 //------------------------------------------------------------------------------
 
 // inputLoop is an internal loop that brokers incoming messages to the buffer.
@@ -124,19 +150,38 @@ func (m *Stream) inputLoop() {
 
 // outputLoop is an internal loop brokers buffer messages to output pipe.
 func (m *Stream) outputLoop() {
+	// closedWG is only marked done once the buffer has been fully closed,
+	// which for an AsyncCloser may occur well after messagesOut has already
+	// been closed and downstream unblocked.
+	defer m.closedWG.Done()
+
 	defer func() {
+		if ac, ok := m.buffer.(AsyncCloser); ok {
+			asyncDone := make(chan struct{})
+			if err := ac.AsyncClose(func() { close(asyncDone) }); err != nil {
+				m.log.Errorf("Failed to close buffer asynchronously: %v\n", err)
+				close(asyncDone)
+			}
+			close(m.messagesOut)
+			close(m.drainedChan)
+			<-asyncDone
+			return
+		}
 		_ = m.buffer.Close(context.Background())
 		close(m.messagesOut)
-		m.closedWG.Done()
+		close(m.drainedChan)
 	}()
 
 	var (
 		mReadCount   = m.stats.GetCounter("read.count")
 		mReadErr     = m.stats.GetCounter("read.error")
+		mReadRetry   = m.stats.GetCounter("read.retry")
+		mCircuitOpen = m.stats.GetGauge("read.circuit_open")
 		mSendSuccess = m.stats.GetCounter("send.success")
 		mSendErr     = m.stats.GetCounter("send.error")
 		mAckErr      = m.stats.GetCounter("ack.error")
 		mLatency     = m.stats.GetTimer("latency")
+		readAttempt  int
 	)
 
 	closeNowCtx, done := m.shutSig.CloseNowCtx(context.Background())
@@ -147,8 +192,24 @@ func (m *Stream) outputLoop() {
 		if err != nil {
 			if err != types.ErrTypeClosed && !errors.Is(err, context.Canceled) {
 				mReadErr.Incr(1)
-				m.log.Errorf("Failed to read buffer: %v\n", err)
-				if !m.errThrottle.Retry() {
+				readAttempt++
+				backoff, retry := m.retryPolicy.NextBackoff(readAttempt, err)
+				if cs, ok := m.retryPolicy.(CircuitStater); ok {
+					if cs.CircuitOpen() {
+						mCircuitOpen.Set(1)
+					} else {
+						mCircuitOpen.Set(0)
+					}
+				}
+				if !retry {
+					m.log.Errorf("Giving up reading buffer after %v attempts: %v\n", readAttempt, err)
+					return
+				}
+				mReadRetry.Incr(1)
+				m.log.Warnf("Failed to read buffer (attempt %v, retrying in %v): %v\n", readAttempt, backoff, err)
+				select {
+				case <-time.After(backoff):
+				case <-m.shutSig.CloseNowChan():
 					return
 				}
 			} else {
@@ -162,7 +223,11 @@ func (m *Stream) outputLoop() {
 		tracing.InitSpans(m.typeStr, msg)
 
 		mReadCount.Incr(1)
-		m.errThrottle.Reset()
+		readAttempt = 0
+		m.retryPolicy.Reset()
+		if cs, ok := m.retryPolicy.(CircuitStater); ok && !cs.CircuitOpen() {
+			mCircuitOpen.Set(0)
+		}
 
 		resChan := make(chan types.Response, 1)
 		select {
@@ -218,6 +283,15 @@ func (m *Stream) TransactionChan() <-chan types.Transaction {
 	return m.messagesOut
 }
 
+// DrainedChan returns a channel that is closed once the Stream has stopped
+// producing transactions and messagesOut has been closed. For buffers that
+// implement AsyncCloser this close phase may complete well before the
+// underlying buffer has finished persisting its remaining data, which is
+// only guaranteed once WaitForClose returns.
+func (m *Stream) DrainedChan() <-chan struct{} {
+	return m.drainedChan
+}
+
 // CloseAsync shuts down the Stream and stops processing messages.
 func (m *Stream) CloseAsync() {
 	m.shutSig.CloseNow()
@@ -229,7 +303,10 @@ func (m *Stream) StopConsuming() {
 	m.shutSig.CloseAtLeisure()
 }
 
-// WaitForClose blocks until the Stream output has closed down.
+// WaitForClose blocks until the Stream has fully closed down, meaning the
+// underlying buffer has finished persisting any outstanding data (see
+// AsyncCloser). Callers that only need to know when the Stream has stopped
+// producing transactions can instead wait on DrainedChan.
 func (m *Stream) WaitForClose(timeout time.Duration) error {
 	select {
 	case <-m.shutSig.HasClosedChan():
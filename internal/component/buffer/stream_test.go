@@ -0,0 +1,95 @@
+package buffer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+	"github.com/Jeffail/benthos/v3/lib/types"
+)
+
+// fakeAsyncCloserBuffer is a ReaderWriter that also implements AsyncCloser,
+// letting AsyncClose be held open under test control so the ordering of
+// DrainedChan, onDone and WaitForClose can be asserted independently.
+type fakeAsyncCloserBuffer struct {
+	asyncDone chan struct{}
+}
+
+func newFakeAsyncCloserBuffer() *fakeAsyncCloserBuffer {
+	return &fakeAsyncCloserBuffer{}
+}
+
+func (f *fakeAsyncCloserBuffer) Read(ctx context.Context) (types.Message, AckFunc, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+func (f *fakeAsyncCloserBuffer) Write(ctx context.Context, _ types.Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeAsyncCloserBuffer) EndOfInput() {}
+
+func (f *fakeAsyncCloserBuffer) Close(context.Context) error { return nil }
+
+// AsyncClose doesn't call onDone until the test releases it via
+// finishAsyncClose, so a caller can observe DrainedChan closing well before
+// the buffer's own close completes.
+func (f *fakeAsyncCloserBuffer) AsyncClose(onDone func()) error {
+	f.asyncDone = make(chan struct{})
+	go func() {
+		<-f.asyncDone
+		onDone()
+	}()
+	return nil
+}
+
+func (f *fakeAsyncCloserBuffer) finishAsyncClose() {
+	close(f.asyncDone)
+}
+
+func TestStreamAsyncCloserDrainsBeforeClose(t *testing.T) {
+	buf := newFakeAsyncCloserBuffer()
+	s := NewStream("test", buf, log.Noop(), metrics.Noop()).(*Stream)
+
+	msgsIn := make(chan types.Transaction)
+	if err := s.Consume(msgsIn); err != nil {
+		t.Fatal(err)
+	}
+	defer close(msgsIn)
+
+	s.CloseAsync()
+
+	select {
+	case <-s.DrainedChan():
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for DrainedChan to close")
+	}
+
+	if _, open := <-s.TransactionChan(); open {
+		t.Fatal("expected messagesOut to be closed once DrainedChan closes")
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- s.WaitForClose(time.Second) }()
+
+	select {
+	case err := <-waitErr:
+		t.Fatalf("WaitForClose returned (err=%v) before onDone was called", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	buf.finishAsyncClose()
+
+	select {
+	case err := <-waitErr:
+		if err != nil {
+			t.Fatalf("unexpected error from WaitForClose: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for WaitForClose to return after onDone")
+	}
+}
@@ -0,0 +1,350 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+// batchingPollInterval bounds how promptly a period trigger is noticed in
+// the absence of further writes.
+const batchingPollInterval = time.Millisecond * 10
+
+// BatchingBatchBufferConfig configures a BatchingBatchBuffer.
+type BatchingBatchBufferConfig struct {
+	// Count is the number of messages that triggers a flush. Zero disables
+	// this trigger.
+	Count int
+
+	// ByteSize is the total payload size, in bytes, across buffered
+	// messages that triggers a flush. Zero disables this trigger.
+	ByteSize int
+
+	// Period is the maximum age of the oldest buffered message before a
+	// flush is triggered. Zero disables this trigger.
+	Period time.Duration
+}
+
+// BatchingBatchBuffer wraps another BatchBuffer (or, when none is provided,
+// acts as one directly over an in-memory queue) and coalesces small incoming
+// writes into larger read batches. A flush is triggered by whichever of
+// Count, ByteSize or Period fires first.
+type BatchingBatchBuffer struct {
+	conf  BatchingBatchBufferConfig
+	log   log.Modular
+	inner BatchBuffer
+
+	mut          sync.Mutex
+	closed       bool
+	endOfInput   bool
+	pending      MessageBatch
+	pendingBytes int
+	oldestAt     time.Time
+	largestBatch int
+
+	// Only used when inner is nil.
+	queue []MessageBatch
+
+	// flushQueue holds completed batches in the order they triggered, to be
+	// forwarded to inner (or queue) one at a time by flushLoop. Using a
+	// single loop instead of a goroutine per flush guarantees batches reach
+	// inner in the order they completed.
+	flushQueue []MessageBatch
+	flushing   bool
+	flushSig   chan struct{}
+
+	wakeChan  chan struct{}
+	closeChan chan struct{}
+	closeOnce sync.Once
+
+	mCountTriggered    metrics.StatCounter
+	mByteSizeTriggered metrics.StatCounter
+	mPeriodTriggered   metrics.StatCounter
+	mFlushTriggered    metrics.StatCounter
+	mBatchesEmitted    metrics.StatCounter
+	mMessagesEmitted   metrics.StatCounter
+	mLargestBatch      metrics.StatGauge
+	mEmitErr           metrics.StatCounter
+}
+
+// NewBatchingBatchBuffer creates a BatchBuffer that batches small writes into
+// larger reads. If inner is non-nil it is used as the underlying store for
+// completed batches, and ReadBatch/its ack func delegate to it directly.
+// If inner is nil, completed batches are held in memory and the returned ack
+// func is a no-op.
+func NewBatchingBatchBuffer(conf BatchingBatchBufferConfig, log log.Modular, stats metrics.Type, inner BatchBuffer) (*BatchingBatchBuffer, error) {
+	if conf.Count <= 0 && conf.ByteSize <= 0 && conf.Period <= 0 {
+		return nil, errors.New("at least one of count, byte_size or period must be set")
+	}
+	b := &BatchingBatchBuffer{
+		conf:               conf,
+		log:                log,
+		inner:              inner,
+		flushSig:           make(chan struct{}, 1),
+		wakeChan:           make(chan struct{}, 1),
+		closeChan:          make(chan struct{}),
+		mCountTriggered:    stats.GetCounter("batching.trigger.count"),
+		mByteSizeTriggered: stats.GetCounter("batching.trigger.byte_size"),
+		mPeriodTriggered:   stats.GetCounter("batching.trigger.period"),
+		mFlushTriggered:    stats.GetCounter("batching.trigger.flush"),
+		mBatchesEmitted:    stats.GetCounter("batching.emitted.batches"),
+		mMessagesEmitted:   stats.GetCounter("batching.emitted.messages"),
+		mLargestBatch:      stats.GetGauge("batching.largest_batch"),
+		mEmitErr:           stats.GetCounter("batching.emit.error"),
+	}
+	go b.periodLoop()
+	go b.flushLoop()
+	return b, nil
+}
+
+//------------------------------------------------------------------------------
+
+// WriteBatch appends the batch to the pending buffer, acking immediately,
+// and flushes (forwarding downstream) as soon as a trigger condition is met.
+func (b *BatchingBatchBuffer) WriteBatch(ctx context.Context, batch MessageBatch) error {
+	b.mut.Lock()
+	if b.closed {
+		b.mut.Unlock()
+		return errors.New("buffer is closed")
+	}
+	if len(b.pending) == 0 {
+		b.oldestAt = time.Now()
+	}
+	b.pending = append(b.pending, batch...)
+	for _, m := range batch {
+		if raw, err := m.AsBytes(); err == nil {
+			b.pendingBytes += len(raw)
+		}
+	}
+
+	var flush MessageBatch
+	switch {
+	case b.conf.Count > 0 && len(b.pending) >= b.conf.Count:
+		b.mCountTriggered.Incr(1)
+		flush = b.takePendingLocked()
+	case b.conf.ByteSize > 0 && b.pendingBytes >= b.conf.ByteSize:
+		b.mByteSizeTriggered.Incr(1)
+		flush = b.takePendingLocked()
+	}
+	b.mut.Unlock()
+
+	// The flush (if any) is forwarded via flushLoop: WriteBatch acks upstream
+	// as soon as the messages are appended to the pending buffer, regardless
+	// of how long the underlying store takes to accept the completed batch.
+	if flush != nil {
+		b.queueFlush(flush)
+	}
+	return nil
+}
+
+// queueFlush appends batch to flushQueue and wakes flushLoop. Every trigger
+// path (count/byte_size, period, EndOfInput) goes through this single queue
+// so that batches always reach inner in the order they completed, rather
+// than racing across one goroutine per flush.
+func (b *BatchingBatchBuffer) queueFlush(batch MessageBatch) {
+	b.mut.Lock()
+	b.flushQueue = append(b.flushQueue, batch)
+	b.mut.Unlock()
+	select {
+	case b.flushSig <- struct{}{}:
+	default:
+	}
+}
+
+// flushLoop forwards queued batches to inner (or the in-memory queue) one at
+// a time, in order, until closeChan fires and the queue has been drained.
+func (b *BatchingBatchBuffer) flushLoop() {
+	for {
+		select {
+		case <-b.flushSig:
+		case <-b.closeChan:
+		}
+		for {
+			b.mut.Lock()
+			if len(b.flushQueue) == 0 {
+				b.flushing = false
+				b.mut.Unlock()
+				break
+			}
+			batch := b.flushQueue[0]
+			b.flushQueue = b.flushQueue[1:]
+			b.flushing = true
+			b.mut.Unlock()
+			b.emit(context.Background(), batch)
+		}
+		select {
+		case <-b.closeChan:
+			return
+		default:
+		}
+	}
+}
+
+// waitFlushDrained blocks until flushQueue has been fully forwarded to
+// inner, so that a caller about to signal EndOfInput downstream can be sure
+// the final batch arrived first.
+func (b *BatchingBatchBuffer) waitFlushDrained() {
+	ticker := time.NewTicker(batchingPollInterval)
+	defer ticker.Stop()
+	for {
+		b.mut.Lock()
+		drained := len(b.flushQueue) == 0 && !b.flushing
+		b.mut.Unlock()
+		if drained {
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// takePendingLocked must be called with mut held. It detaches and returns
+// the current pending buffer, resetting it to empty.
+func (b *BatchingBatchBuffer) takePendingLocked() MessageBatch {
+	flush := b.pending
+	b.pending = nil
+	b.pendingBytes = 0
+	return flush
+}
+
+// emit forwards a completed batch downstream, either to the wrapped buffer
+// or the in-memory queue, and records size metrics. A failed write to inner
+// is logged and counted rather than silently dropped - by the time emit
+// runs, WriteBatch has already acked the batch upstream, so this is the only
+// remaining point where such a failure can be surfaced.
+func (b *BatchingBatchBuffer) emit(ctx context.Context, batch MessageBatch) {
+	b.mBatchesEmitted.Incr(1)
+	b.mMessagesEmitted.Incr(int64(len(batch)))
+	b.mut.Lock()
+	if len(batch) > b.largestBatch {
+		b.largestBatch = len(batch)
+		b.mLargestBatch.Set(int64(b.largestBatch))
+	}
+	b.mut.Unlock()
+
+	if b.inner != nil {
+		if err := b.inner.WriteBatch(ctx, batch); err != nil {
+			b.mEmitErr.Incr(1)
+			b.log.Errorf("Failed to write batched messages to inner buffer: %v\n", err)
+		}
+		return
+	}
+
+	b.mut.Lock()
+	b.queue = append(b.queue, batch)
+	b.mut.Unlock()
+
+	select {
+	case b.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// periodLoop periodically checks whether the oldest buffered message has
+// exceeded Period, flushing if so.
+func (b *BatchingBatchBuffer) periodLoop() {
+	if b.conf.Period <= 0 {
+		return
+	}
+	ticker := time.NewTicker(batchingPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.closeChan:
+			return
+		}
+
+		b.mut.Lock()
+		var flush MessageBatch
+		if len(b.pending) > 0 && time.Since(b.oldestAt) >= b.conf.Period {
+			b.mPeriodTriggered.Incr(1)
+			flush = b.takePendingLocked()
+		}
+		b.mut.Unlock()
+
+		if flush != nil {
+			b.queueFlush(flush)
+		}
+	}
+}
+
+// ReadBatch returns the next completed batch. When wrapping another
+// BatchBuffer this delegates directly to it; otherwise it reads from the
+// in-memory queue and returns a no-op ack func.
+func (b *BatchingBatchBuffer) ReadBatch(ctx context.Context) (MessageBatch, AckFunc, error) {
+	if b.inner != nil {
+		return b.inner.ReadBatch(ctx)
+	}
+	for {
+		b.mut.Lock()
+		if len(b.queue) > 0 {
+			batch := b.queue[0]
+			b.queue = b.queue[1:]
+			b.mut.Unlock()
+			return batch, func(context.Context, error) error { return nil }, nil
+		}
+		done := b.endOfInput
+		b.mut.Unlock()
+		if done {
+			return nil, nil, ErrEndOfBuffer
+		}
+		select {
+		case <-b.wakeChan:
+		case <-b.closeChan:
+			return nil, nil, ErrEndOfBuffer
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// EndOfInput flushes any partial batch still pending and signals downstream
+// that no further batches will be written.
+func (b *BatchingBatchBuffer) EndOfInput() {
+	b.mut.Lock()
+	if b.endOfInput {
+		b.mut.Unlock()
+		return
+	}
+	b.endOfInput = true
+	var flush MessageBatch
+	if len(b.pending) > 0 {
+		b.mFlushTriggered.Incr(1)
+		flush = b.takePendingLocked()
+	}
+	b.mut.Unlock()
+
+	if flush != nil {
+		b.queueFlush(flush)
+	}
+	// Every batch queued so far - this final one and any still in flight
+	// from an earlier trigger - must reach inner before it's told input has
+	// ended.
+	b.waitFlushDrained()
+
+	if b.inner != nil {
+		b.inner.EndOfInput()
+	} else {
+		select {
+		case b.wakeChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close stops the background period checker and, if wrapping another
+// buffer, closes it too.
+func (b *BatchingBatchBuffer) Close(ctx context.Context) error {
+	b.mut.Lock()
+	b.closed = true
+	b.mut.Unlock()
+	b.closeOnce.Do(func() { close(b.closeChan) })
+	if b.inner != nil {
+		return b.inner.Close(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jeffail/benthos/v3/lib/log"
+	"github.com/Jeffail/benthos/v3/lib/metrics"
+)
+
+// fakeOrderedBatchBuffer is a minimal BatchBuffer used to assert the order
+// and success/failure of writes made by a BatchingBatchBuffer wrapping it.
+type fakeOrderedBatchBuffer struct {
+	mut      sync.Mutex
+	written  []string
+	failNext bool
+}
+
+func (f *fakeOrderedBatchBuffer) WriteBatch(_ context.Context, b MessageBatch) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if f.failNext {
+		f.failNext = false
+		return errors.New("simulated write failure")
+	}
+	raw, _ := b[0].AsBytes()
+	f.written = append(f.written, string(raw))
+	return nil
+}
+
+func (f *fakeOrderedBatchBuffer) ReadBatch(ctx context.Context) (MessageBatch, AckFunc, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+func (f *fakeOrderedBatchBuffer) EndOfInput() {}
+
+func (f *fakeOrderedBatchBuffer) Close(context.Context) error { return nil }
+
+func TestBatchingBatchBufferCountTrigger(t *testing.T) {
+	b, err := NewBatchingBatchBuffer(BatchingBatchBufferConfig{Count: 2}, log.Noop(), metrics.Noop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := b.WriteBatch(ctx, MessageBatch{NewMessage([]byte("a"))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteBatch(ctx, MessageBatch{NewMessage([]byte("b"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, _, err := b.ReadBatch(readCtx)
+	if err != nil {
+		t.Fatalf("timed out waiting for count-triggered batch: %v", err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("expected batch of 2, got %d", len(batch))
+	}
+}
+
+func TestBatchingBatchBufferPeriodTrigger(t *testing.T) {
+	b, err := NewBatchingBatchBuffer(BatchingBatchBufferConfig{Period: 20 * time.Millisecond}, log.Noop(), metrics.Noop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := b.WriteBatch(ctx, MessageBatch{NewMessage([]byte("a"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, _, err := b.ReadBatch(readCtx)
+	if err != nil {
+		t.Fatalf("timed out waiting for period-triggered batch: %v", err)
+	}
+	if len(batch) != 1 {
+		t.Fatalf("expected batch of 1, got %d", len(batch))
+	}
+}
+
+func TestBatchingBatchBufferShutdownFlush(t *testing.T) {
+	b, err := NewBatchingBatchBuffer(BatchingBatchBufferConfig{Count: 10}, log.Noop(), metrics.Noop(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := b.WriteBatch(ctx, MessageBatch{NewMessage([]byte("a"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The count trigger won't fire on its own, but EndOfInput must flush the
+	// partial batch immediately so the pipeline can shut down.
+	b.EndOfInput()
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, ackFn, err := b.ReadBatch(readCtx)
+	if err != nil {
+		t.Fatalf("timed out waiting for flushed batch: %v", err)
+	}
+	if len(batch) != 1 {
+		t.Fatalf("expected flushed batch of 1, got %d", len(batch))
+	}
+	if err := ackFn(ctx, nil); err != nil {
+		t.Fatalf("expected no-op ack func to succeed, got %v", err)
+	}
+
+	if _, _, err := b.ReadBatch(ctx); err != ErrEndOfBuffer {
+		t.Fatalf("expected ErrEndOfBuffer after flush drained, got %v", err)
+	}
+}
+
+func TestBatchingBatchBufferInnerWritesAreOrderedAfterFailure(t *testing.T) {
+	inner := &fakeOrderedBatchBuffer{failNext: true}
+	b, err := NewBatchingBatchBuffer(BatchingBatchBufferConfig{Count: 1}, log.Noop(), metrics.Noop(), inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	// The first batch's inner write is made to fail; WriteBatch must still
+	// ack it upstream (the buffer only decouples, it doesn't retry), and the
+	// failure must not stall or reorder the batches that follow.
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := b.WriteBatch(ctx, MessageBatch{NewMessage([]byte(payload))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		inner.mut.Lock()
+		n := len(inner.written)
+		inner.mut.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for inner writes, got %v so far", inner.written)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	inner.mut.Lock()
+	defer inner.mut.Unlock()
+	if got := inner.written; len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Fatalf("expected the failed batch to be dropped and the rest forwarded in order, got %v", got)
+	}
+}
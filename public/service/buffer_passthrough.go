@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// passthroughWatermarkInterval is the period at which PassthroughBatchBuffer
+// re-scans its pending entries in order to advance the persisted index
+// watermark.
+const passthroughWatermarkInterval = time.Millisecond * 10
+
+// PassthroughBatchBuffer is a BatchBuffer implementation that never stores
+// message payloads of its own. Instead, each batch passed to WriteBatch is
+// assigned a strictly increasing index and held in memory only until the
+// output has confirmed it has been fully processed, at which point the
+// upstream acknowledgement is released.
+//
+// This gives the "shipper" or "proxy queue" buffer pattern: at-least-once
+// delivery is preserved because the input is only acked once the output has
+// confirmed the batch has been fully processed, and the input is decoupled
+// from how the output chooses to process a batch (retries, multiplexing to
+// several outputs, etc) without ever holding a second copy of the payload.
+//
+// This is NOT a burst-smoothing ring buffer, despite the index-keyed pending
+// map resembling one. WriteBatch blocks until its own batch is acknowledged,
+// and a Stream only ever has one WriteBatch in flight at a time, so in
+// practice at most one entry is ever pending here. A burst of writes gets no
+// smoothing at all - each one is fully serialized with the next. What this
+// buffer actually buys is the at-least-once/no-double-buffering tradeoff
+// described above, not throughput smoothing; pick WindowBatchBuffer or
+// BatchingBatchBuffer instead if absorbing bursts is the goal.
+type PassthroughBatchBuffer struct {
+	drainTimeout time.Duration
+
+	mut        sync.Mutex
+	closed     bool
+	endOfInput bool
+	nextIndex  uint64
+	watermark  uint64
+	pending    map[uint64]*passthroughEntry
+	acked      map[uint64]error
+
+	readChan  chan *passthroughEntry
+	wakeChan  chan struct{}
+	inputDone chan struct{}
+	closeChan chan struct{}
+
+	closeOnce sync.Once
+}
+
+// errAbandonedEntry marks an index that was assigned but never handed to a
+// reader (its WriteBatch call was cancelled first) as resolved, so the
+// watermark does not stall waiting for an ack that will never come.
+var errAbandonedEntry = errors.New("entry abandoned before being read")
+
+type passthroughEntry struct {
+	index      uint64
+	batch      MessageBatch
+	resultChan chan error
+}
+
+// NewPassthroughBatchBuffer creates a BatchBuffer that performs no payload
+// storage of its own, instead relaying acknowledgements from the output back
+// to the input once a monotonic persisted index watermark confirms that a
+// batch has been fully processed downstream.
+//
+// The drainTimeout bounds how long Close will wait, once no more batches are
+// being written, for in-flight batches to be acknowledged before giving up
+// and nacking whatever remains pending.
+func NewPassthroughBatchBuffer(drainTimeout time.Duration) *PassthroughBatchBuffer {
+	p := &PassthroughBatchBuffer{
+		drainTimeout: drainTimeout,
+		nextIndex:    1,
+		pending:      map[uint64]*passthroughEntry{},
+		acked:        map[uint64]error{},
+		readChan:     make(chan *passthroughEntry),
+		wakeChan:     make(chan struct{}, 1),
+		inputDone:    make(chan struct{}),
+		closeChan:    make(chan struct{}),
+	}
+	go p.watermarkLoop()
+	return p
+}
+
+//------------------------------------------------------------------------------
+
+// WriteBatch assigns the batch the next persisted index and blocks until
+// either the batch has been fully acknowledged downstream (in which case the
+// recorded ack error, if any, is returned) or the provided context is
+// cancelled.
+func (p *PassthroughBatchBuffer) WriteBatch(ctx context.Context, b MessageBatch) error {
+	p.mut.Lock()
+	if p.closed {
+		p.mut.Unlock()
+		return errors.New("buffer is closed")
+	}
+	index := p.nextIndex
+	p.nextIndex++
+	entry := &passthroughEntry{
+		index:      index,
+		batch:      b,
+		resultChan: make(chan error, 1),
+	}
+	p.pending[index] = entry
+	p.mut.Unlock()
+
+	select {
+	case p.readChan <- entry:
+	case <-ctx.Done():
+		p.abandon(index)
+		return ctx.Err()
+	case <-p.closeChan:
+		p.abandon(index)
+		return errors.New("buffer is closed")
+	}
+
+	select {
+	case err := <-entry.resultChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ReadBatch returns the next written batch, or ErrEndOfBuffer once
+// EndOfInput has been called and no further batches are pending delivery.
+func (p *PassthroughBatchBuffer) ReadBatch(ctx context.Context) (MessageBatch, AckFunc, error) {
+	select {
+	case entry := <-p.readChan:
+		return entry.batch, p.ackFuncFor(entry.index), nil
+	case <-p.inputDone:
+		select {
+		case entry := <-p.readChan:
+			return entry.batch, p.ackFuncFor(entry.index), nil
+		default:
+			return nil, nil, ErrEndOfBuffer
+		}
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// abandon removes index from pending without ever having handed it to a
+// reader, and immediately marks it acked so advanceWatermark treats it as an
+// already-resolved entry rather than a gap. Without this, an index whose
+// WriteBatch call was cancelled before the entry reached readChan would never
+// be acked, permanently stalling the watermark at that index and blocking
+// every later, already-acked entry behind it.
+func (p *PassthroughBatchBuffer) abandon(index uint64) {
+	p.mut.Lock()
+	delete(p.pending, index)
+	p.acked[index] = errAbandonedEntry
+	p.mut.Unlock()
+	select {
+	case p.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// ackFuncFor returns an AckFunc that merely records the outcome of
+// processing the batch at index. The actual release of the upstream
+// acknowledgement happens once the watermark loop observes that index has
+// become part of the contiguous persisted run.
+func (p *PassthroughBatchBuffer) ackFuncFor(index uint64) AckFunc {
+	return func(_ context.Context, ackErr error) error {
+		p.mut.Lock()
+		p.acked[index] = ackErr
+		p.mut.Unlock()
+		select {
+		case p.wakeChan <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+}
+
+// watermarkLoop periodically advances the persisted index watermark - the
+// largest contiguous acked index - and resolves every pending entry whose
+// index has become part of that contiguous run, in order.
+func (p *PassthroughBatchBuffer) watermarkLoop() {
+	ticker := time.NewTicker(passthroughWatermarkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.wakeChan:
+		case <-ticker.C:
+		case <-p.closeChan:
+			return
+		}
+		p.advanceWatermark()
+	}
+}
+
+func (p *PassthroughBatchBuffer) advanceWatermark() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for {
+		next := p.watermark + 1
+		err, ok := p.acked[next]
+		if !ok {
+			return
+		}
+		entry := p.pending[next]
+		delete(p.acked, next)
+		delete(p.pending, next)
+		p.watermark = next
+		if entry != nil {
+			entry.resultChan <- err
+		}
+	}
+}
+
+// EndOfInput indicates that no further batches will be written.
+func (p *PassthroughBatchBuffer) EndOfInput() {
+	p.mut.Lock()
+	alreadyDone := p.endOfInput
+	p.endOfInput = true
+	p.mut.Unlock()
+	if !alreadyDone {
+		close(p.inputDone)
+	}
+}
+
+// Close refuses further writes, waits for the persisted index to catch up
+// with the highest issued index (or for drainTimeout/ctx to expire) and then
+// nacks any batches still pending acknowledgement.
+func (p *PassthroughBatchBuffer) Close(ctx context.Context) error {
+	p.closeOnce.Do(func() {
+		p.mut.Lock()
+		p.closed = true
+		highest := p.nextIndex - 1
+		p.mut.Unlock()
+
+		deadline := ctx
+		var cancel context.CancelFunc
+		if p.drainTimeout > 0 {
+			deadline, cancel = context.WithTimeout(ctx, p.drainTimeout)
+			defer cancel()
+		}
+
+		ticker := time.NewTicker(passthroughWatermarkInterval)
+		defer ticker.Stop()
+	waitLoop:
+		for {
+			p.mut.Lock()
+			wm := p.watermark
+			p.mut.Unlock()
+			if wm >= highest {
+				break waitLoop
+			}
+			select {
+			case <-ticker.C:
+			case <-deadline.Done():
+				break waitLoop
+			}
+		}
+
+		p.mut.Lock()
+		for index, entry := range p.pending {
+			entry.resultChan <- errors.New("buffer closed before batch was acknowledged")
+			delete(p.pending, index)
+		}
+		p.mut.Unlock()
+
+		close(p.closeChan)
+	})
+	return nil
+}
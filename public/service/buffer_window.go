@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WindowMode describes the strategy a WindowBatchBuffer uses to group
+// messages into batches based on time.
+type WindowMode string
+
+const (
+	// WindowModeTumbling groups messages into fixed size, non-overlapping
+	// windows aligned to wall clock boundaries.
+	WindowModeTumbling WindowMode = "tumbling"
+
+	// WindowModeSliding groups messages into fixed size windows that advance
+	// by a step smaller than the window size, so that a single message may
+	// be emitted as part of more than one window.
+	WindowModeSliding WindowMode = "sliding"
+
+	// WindowModeSession groups messages per key into windows that close
+	// after a configured gap of inactivity is observed for that key.
+	WindowModeSession WindowMode = "session"
+)
+
+// WindowKeyFunc extracts the grouping key for a message. Messages that
+// produce the same key are windowed independently of messages with other
+// keys.
+type WindowKeyFunc func(*Message) (string, error)
+
+// WindowTimestampFunc extracts the event timestamp of a message. When nil is
+// supplied to NewWindowBatchBuffer the arrival time (time.Now at the point
+// WriteBatch is called) is used instead.
+type WindowTimestampFunc func(*Message) (time.Time, error)
+
+// WindowBatchBufferConfig configures a WindowBatchBuffer.
+type WindowBatchBufferConfig struct {
+	// Mode selects the windowing strategy: tumbling, sliding or session.
+	Mode WindowMode
+
+	// Size is the window length for tumbling and sliding windows.
+	Size time.Duration
+
+	// Slide is the step between the start of consecutive sliding windows.
+	// It must be greater than zero and less than Size. Ignored
+	// outside of WindowModeSliding.
+	Slide time.Duration
+
+	// Gap is the period of inactivity, per key, after which a session
+	// window is closed. Ignored outside of WindowModeSession.
+	Gap time.Duration
+
+	// AllowedLateness is the extra period a window remains open past its
+	// natural close time in order to accommodate out-of-order arrivals.
+	AllowedLateness time.Duration
+
+	// Key extracts the grouping key of a message. If nil, all messages share
+	// a single key.
+	Key WindowKeyFunc
+
+	// Timestamp extracts the event timestamp of a message. If nil, the
+	// arrival time is used, which disables meaningful allowed-lateness
+	// handling.
+	Timestamp WindowTimestampFunc
+}
+
+// WindowBatchBuffer is a BatchBuffer that groups messages into batches using
+// tumbling, sliding or session windows, as described in the temporal buffer
+// use case outlined by the BatchBuffer interface. Because the emitted
+// batches are a synthesis of many input messages rather than a pass through
+// of them, the ack func returned from ReadBatch is a no-op: acknowledgement
+// of the window batch is meaningless once its constituent messages have
+// already been merged.
+type WindowBatchBuffer struct {
+	conf WindowBatchBufferConfig
+
+	mut        sync.Mutex
+	closed     bool
+	endOfInput bool
+	watermark  time.Time
+	windows    map[windowKey]*windowState
+	ready      []MessageBatch
+
+	wakeChan  chan struct{}
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+type windowKey struct {
+	key   string
+	start int64 // UnixNano
+}
+
+type windowState struct {
+	start    time.Time
+	end      time.Time
+	messages MessageBatch
+}
+
+// NewWindowBatchBuffer creates a BatchBuffer that buckets messages into
+// tumbling, sliding or session windows and emits each completed window as a
+// single MessageBatch.
+func NewWindowBatchBuffer(conf WindowBatchBufferConfig) (*WindowBatchBuffer, error) {
+	switch conf.Mode {
+	case WindowModeTumbling:
+		if conf.Size <= 0 {
+			return nil, errors.New("tumbling windows require a positive size")
+		}
+	case WindowModeSliding:
+		if conf.Size <= 0 || conf.Slide <= 0 || conf.Slide >= conf.Size {
+			return nil, errors.New("sliding windows require 0 < slide < size")
+		}
+	case WindowModeSession:
+		if conf.Gap <= 0 {
+			return nil, errors.New("session windows require a positive gap")
+		}
+	default:
+		return nil, errors.New("window mode must be one of: tumbling, sliding, session")
+	}
+	w := &WindowBatchBuffer{
+		conf:      conf,
+		windows:   map[windowKey]*windowState{},
+		wakeChan:  make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+	}
+	return w, nil
+}
+
+//------------------------------------------------------------------------------
+
+func (w *WindowBatchBuffer) keyOf(m *Message) (string, error) {
+	if w.conf.Key == nil {
+		return "", nil
+	}
+	return w.conf.Key(m)
+}
+
+func (w *WindowBatchBuffer) timestampOf(m *Message) (time.Time, error) {
+	if w.conf.Timestamp == nil {
+		return time.Now(), nil
+	}
+	return w.conf.Timestamp(m)
+}
+
+// WriteBatch buckets each message of the batch into its window(s) according
+// to the configured mode.
+func (w *WindowBatchBuffer) WriteBatch(ctx context.Context, b MessageBatch) error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return errors.New("buffer is closed")
+	}
+	for _, m := range b {
+		key, err := w.keyOf(m)
+		if err != nil {
+			w.mut.Unlock()
+			return err
+		}
+		ts, err := w.timestampOf(m)
+		if err != nil {
+			w.mut.Unlock()
+			return err
+		}
+		if ts.After(w.watermark) {
+			w.watermark = ts
+		}
+		switch w.conf.Mode {
+		case WindowModeTumbling:
+			w.assign(key, ts.Truncate(w.conf.Size), w.conf.Size, m)
+		case WindowModeSliding:
+			w.assignSliding(key, ts, m)
+		case WindowModeSession:
+			w.ready = append(w.ready, w.assignSession(key, ts, m)...)
+		}
+	}
+	w.ready = append(w.ready, w.collectTriggered()...)
+	w.mut.Unlock()
+
+	select {
+	case w.wakeChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *WindowBatchBuffer) assign(key string, start time.Time, size time.Duration, m *Message) {
+	k := windowKey{key: key, start: start.UnixNano()}
+	s, ok := w.windows[k]
+	if !ok {
+		s = &windowState{start: start, end: start.Add(size)}
+		w.windows[k] = s
+	}
+	s.messages = append(s.messages, m)
+}
+
+func (w *WindowBatchBuffer) assignSliding(key string, ts time.Time, m *Message) {
+	// A message at ts belongs to every sliding window whose start is a
+	// multiple of Slide such that start <= ts < start+Size.
+	firstStart := ts.Add(-w.conf.Size + w.conf.Slide).Truncate(w.conf.Slide)
+	for start := firstStart; !start.After(ts); start = start.Add(w.conf.Slide) {
+		if start.Add(w.conf.Size).After(ts) {
+			w.assign(key, start, w.conf.Size, m.Copy())
+		}
+	}
+}
+
+// assignSession buckets m into the open session window for key, closing and
+// returning the previous session's batch first if the inactivity gap has
+// already elapsed. Without this, a session that rolls over while a new
+// message arrives would otherwise be overwritten and its messages lost.
+func (w *WindowBatchBuffer) assignSession(key string, ts time.Time, m *Message) []MessageBatch {
+	k := windowKey{key: key, start: 0}
+	var closed []MessageBatch
+
+	s, ok := w.windows[k]
+	if ok && ts.After(s.end) {
+		if len(s.messages) > 0 {
+			closed = append(closed, s.messages)
+		}
+		delete(w.windows, k)
+		ok = false
+	}
+
+	if !ok {
+		s = &windowState{start: ts, end: ts.Add(w.conf.Gap)}
+		w.windows[k] = s
+	} else if newEnd := ts.Add(w.conf.Gap); newEnd.After(s.end) {
+		s.end = newEnd
+	}
+	if ts.Before(s.start) {
+		s.start = ts
+	}
+	s.messages = append(s.messages, m)
+	return closed
+}
+
+// collectTriggered must be called with mut held. It removes and returns the
+// batches of every window whose close time (plus allowed lateness) has
+// passed the current watermark.
+func (w *WindowBatchBuffer) collectTriggered() []MessageBatch {
+	var out []MessageBatch
+	for k, s := range w.windows {
+		if !w.watermark.Add(-w.conf.AllowedLateness).Before(s.end) {
+			out = append(out, s.messages)
+			delete(w.windows, k)
+		}
+	}
+	return out
+}
+
+// flushAll must be called with mut held. It drains every remaining window
+// regardless of trigger state, used on shutdown.
+func (w *WindowBatchBuffer) flushAll() []MessageBatch {
+	var out []MessageBatch
+	for k, s := range w.windows {
+		if len(s.messages) > 0 {
+			out = append(out, s.messages)
+		}
+		delete(w.windows, k)
+	}
+	return out
+}
+
+var noopAck AckFunc = func(context.Context, error) error { return nil }
+
+// ReadBatch returns the next completed window as a MessageBatch. The
+// returned AckFunc is a no-op.
+func (w *WindowBatchBuffer) ReadBatch(ctx context.Context) (MessageBatch, AckFunc, error) {
+	for {
+		w.mut.Lock()
+		if len(w.ready) > 0 {
+			batch := w.ready[0]
+			w.ready = w.ready[1:]
+			w.mut.Unlock()
+			return batch, noopAck, nil
+		}
+		done := w.endOfInput && len(w.windows) == 0
+		w.mut.Unlock()
+		if done {
+			return nil, nil, ErrEndOfBuffer
+		}
+		select {
+		case <-w.wakeChan:
+		case <-w.closeChan:
+			return nil, nil, ErrEndOfBuffer
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// EndOfInput flushes every remaining window (regardless of whether it has
+// naturally triggered) so that ReadBatch can drain them before reporting
+// ErrEndOfBuffer.
+func (w *WindowBatchBuffer) EndOfInput() {
+	w.mut.Lock()
+	if w.endOfInput {
+		w.mut.Unlock()
+		return
+	}
+	w.endOfInput = true
+	w.ready = append(w.ready, w.flushAll()...)
+	w.mut.Unlock()
+
+	select {
+	case w.wakeChan <- struct{}{}:
+	default:
+	}
+}
+
+// Close shuts the buffer down. Any windows not already flushed by
+// EndOfInput are discarded.
+func (w *WindowBatchBuffer) Close(ctx context.Context) error {
+	w.mut.Lock()
+	w.closed = true
+	w.mut.Unlock()
+	w.closeOnce.Do(func() { close(w.closeChan) })
+	return nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWindowBatchBufferTumblingOutOfOrder(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w, err := NewWindowBatchBuffer(WindowBatchBufferConfig{
+		Mode:            WindowModeTumbling,
+		Size:            time.Minute,
+		AllowedLateness: 30 * time.Second,
+		Timestamp: func(m *Message) (time.Time, error) {
+			raw, _ := m.AsBytes()
+			offset, _ := time.ParseDuration(string(raw))
+			return base.Add(offset), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	write := func(offset string) {
+		if err := w.WriteBatch(ctx, MessageBatch{NewMessage([]byte(offset))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second message to arrive has an earlier event timestamp than the
+	// first, but both land in the same tumbling window. The third message
+	// advances the watermark past the first window's end plus the allowed
+	// lateness, which closes that window holding both earlier arrivals.
+	write("20s")
+	write("10s")
+	write("95s")
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, _, err := w.ReadBatch(readCtx)
+	if err != nil {
+		t.Fatalf("timed out waiting for first window: %v", err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("expected 2 messages in first window, got %d", len(batch))
+	}
+}
+
+func TestWindowBatchBufferShutdownFlush(t *testing.T) {
+	w, err := NewWindowBatchBuffer(WindowBatchBufferConfig{
+		Mode: WindowModeTumbling,
+		Size: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := w.WriteBatch(ctx, MessageBatch{NewMessage([]byte("a"))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The window won't naturally trigger for an hour, but EndOfInput must
+	// flush it immediately so the pipeline can shut down.
+	w.EndOfInput()
+
+	batch, ackFn, err := w.ReadBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 1 {
+		t.Fatalf("expected flushed batch of 1, got %d", len(batch))
+	}
+	if err := ackFn(ctx, nil); err != nil {
+		t.Fatalf("expected no-op ack func to succeed, got %v", err)
+	}
+
+	if _, _, err := w.ReadBatch(ctx); err != ErrEndOfBuffer {
+		t.Fatalf("expected ErrEndOfBuffer after flush drained, got %v", err)
+	}
+}
+
+func TestWindowBatchBufferSlidingOverlap(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w, err := NewWindowBatchBuffer(WindowBatchBufferConfig{
+		Mode:  WindowModeSliding,
+		Size:  time.Minute,
+		Slide: 20 * time.Second,
+		Timestamp: func(m *Message) (time.Time, error) {
+			raw, _ := m.AsBytes()
+			offset, _ := time.ParseDuration(string(raw))
+			return base.Add(offset), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	write := func(offset string) {
+		if err := w.WriteBatch(ctx, MessageBatch{NewMessage([]byte(offset))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A message at 50s falls inside the three overlapping 60s/20s windows
+	// starting at 0s, 20s and 40s. Advancing far past their ends (200s)
+	// closes all three, each independently holding a copy of the message.
+	write("50s")
+	write("200s")
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	seen := 0
+	for i := 0; i < 3; i++ {
+		batch, _, err := w.ReadBatch(readCtx)
+		if err != nil {
+			t.Fatalf("timed out waiting for overlapping window %d: %v", i, err)
+		}
+		if len(batch) != 1 {
+			t.Fatalf("expected 1 message per overlapping window, got %d", len(batch))
+		}
+		seen++
+	}
+	if seen != 3 {
+		t.Fatalf("expected message to be duplicated across 3 overlapping windows, got %d", seen)
+	}
+}
+
+func TestWindowBatchBufferSessionRollover(t *testing.T) {
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w, err := NewWindowBatchBuffer(WindowBatchBufferConfig{
+		Mode: WindowModeSession,
+		Gap:  30 * time.Second,
+		Timestamp: func(m *Message) (time.Time, error) {
+			raw, _ := m.AsBytes()
+			offset, _ := time.ParseDuration(string(raw))
+			return base.Add(offset), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	write := func(offset string) {
+		if err := w.WriteBatch(ctx, MessageBatch{NewMessage([]byte(offset))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first two messages are within the inactivity gap and share a
+	// session. The third arrives long after the gap has elapsed, which must
+	// close and emit the first session rather than silently overwrite it.
+	write("0s")
+	write("20s")
+	write("100s")
+
+	readCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, _, err := w.ReadBatch(readCtx)
+	if err != nil {
+		t.Fatalf("timed out waiting for rolled-over session: %v", err)
+	}
+	if len(batch) != 2 {
+		t.Fatalf("expected rolled-over session to retain both prior messages, got %d", len(batch))
+	}
+}